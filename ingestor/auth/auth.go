@@ -0,0 +1,108 @@
+// Package auth validates bearer tokens against a mutable, in-memory
+// token registry and enforces per-tenant rate limits. Tokens map to a
+// tenant and a service allowlist, so one compromised or misbehaving
+// client can't write to another tenant's data or flood the bucket.
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Token is a bearer token's identity: which tenant it belongs to,
+// which services it may report errors for, and whether it can manage
+// other tokens through the admin API.
+type Token struct {
+	Token           string   `json:"token"`
+	Tenant          string   `json:"tenant"`
+	AllowedServices []string `json:"allowed_services,omitempty"`
+	Admin           bool     `json:"admin,omitempty"`
+}
+
+// Allows reports whether this token may ingest reports for service.
+// Admin tokens may report for any service.
+func (t Token) Allows(service string) bool {
+	if t.Admin {
+		return true
+	}
+	for _, s := range t.AllowedServices {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is a mutex-guarded, in-memory registry of bearer tokens. It
+// can be seeded from the environment at startup and mutated at
+// runtime through the /admin/tokens API, so tokens can be rotated
+// without a redeploy.
+type Store struct {
+	mu     sync.RWMutex
+	tokens map[string]Token
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{tokens: make(map[string]Token)}
+}
+
+// StoreFromEnv builds a Store from AUTH_TOKENS_JSON (a JSON array of
+// Token) and ADMIN_TOKEN (a single bootstrap admin token), so a fresh
+// deploy with ADMIN_TOKEN set has at least one usable token. With
+// neither variable set, the Store is empty.
+func StoreFromEnv() (*Store, error) {
+	s := NewStore()
+
+	if raw, ok := os.LookupEnv("AUTH_TOKENS_JSON"); ok && raw != "" {
+		var tokens []Token
+		if err := json.Unmarshal([]byte(raw), &tokens); err != nil {
+			return nil, fmt.Errorf("auth: parsing AUTH_TOKENS_JSON: %w", err)
+		}
+		for _, t := range tokens {
+			s.Put(t)
+		}
+	}
+
+	if admin, ok := os.LookupEnv("ADMIN_TOKEN"); ok && admin != "" {
+		s.Put(Token{Token: admin, Tenant: "admin", Admin: true})
+	}
+
+	return s, nil
+}
+
+// Lookup returns the Token registered for token, if any.
+func (s *Store) Lookup(token string) (Token, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[token]
+	return t, ok
+}
+
+// Put registers or replaces a token.
+func (s *Store) Put(t Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.Token] = t
+}
+
+// Remove revokes a token. It is a no-op if the token is unknown.
+func (s *Store) Remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+}
+
+// List returns every registered token, including the bootstrap admin
+// token.
+func (s *Store) List() []Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
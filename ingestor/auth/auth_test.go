@@ -0,0 +1,41 @@
+package auth
+
+import "testing"
+
+func TestTokenAllows(t *testing.T) {
+	cases := []struct {
+		name string
+		tok  Token
+		svc  string
+		want bool
+	}{
+		{"admin allows any service", Token{Admin: true}, "billing", true},
+		{"allowlisted service is allowed", Token{AllowedServices: []string{"billing", "auth"}}, "billing", true},
+		{"service outside allowlist is denied", Token{AllowedServices: []string{"billing"}}, "auth", false},
+		{"empty allowlist denies everything", Token{}, "billing", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.tok.Allows(c.svc); got != c.want {
+				t.Errorf("Allows(%q) = %v, want %v", c.svc, got, c.want)
+			}
+		})
+	}
+}
+
+func TestStorePutLookupRemove(t *testing.T) {
+	s := NewStore()
+	tok := Token{Token: "secret", Tenant: "acme"}
+	s.Put(tok)
+
+	got, ok := s.Lookup("secret")
+	if !ok || got != tok {
+		t.Fatalf("Lookup(%q) = %v, %v, want %v, true", "secret", got, ok, tok)
+	}
+
+	s.Remove("secret")
+	if _, ok := s.Lookup("secret"); ok {
+		t.Fatalf("Lookup(%q) still found after Remove", "secret")
+	}
+}
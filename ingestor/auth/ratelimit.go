@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimits holds the token-bucket parameters applied per tenant.
+type RateLimits struct {
+	RPS   float64
+	Burst int
+}
+
+// DefaultRateLimits is used for any field RateLimitsFromEnv can't read
+// from the environment.
+var DefaultRateLimits = RateLimits{RPS: 10, Burst: 20}
+
+// RateLimitsFromEnv reads RATE_LIMIT_RPS and RATE_LIMIT_BURST, falling
+// back to DefaultRateLimits for anything unset or unparsable.
+func RateLimitsFromEnv() RateLimits {
+	limits := DefaultRateLimits
+	if raw, ok := os.LookupEnv("RATE_LIMIT_RPS"); ok {
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			limits.RPS = v
+		}
+	}
+	if raw, ok := os.LookupEnv("RATE_LIMIT_BURST"); ok {
+		if v, err := strconv.Atoi(raw); err == nil {
+			limits.Burst = v
+		}
+	}
+	return limits
+}
+
+// Limiter hands out an independent token-bucket rate limiter per
+// tenant, so one tenant flooding the endpoint can't starve another.
+type Limiter struct {
+	limits RateLimits
+
+	mu        sync.Mutex
+	perTenant map[string]*rate.Limiter
+}
+
+// NewLimiter returns a Limiter that enforces limits independently for
+// each tenant.
+func NewLimiter(limits RateLimits) *Limiter {
+	return &Limiter{limits: limits, perTenant: make(map[string]*rate.Limiter)}
+}
+
+// Allow reports whether tenant may make a request right now, consuming
+// one token from its bucket if so.
+func (l *Limiter) Allow(tenant string) bool {
+	return l.bucket(tenant).Allow()
+}
+
+// RetryAfter returns how long tenant should wait before its next
+// request is likely to be allowed, for use in a Retry-After header.
+func (l *Limiter) RetryAfter(tenant string) time.Duration {
+	r := l.bucket(tenant).Reserve()
+	defer r.Cancel()
+	return r.Delay()
+}
+
+func (l *Limiter) bucket(tenant string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.perTenant[tenant]
+	if !ok {
+		b = rate.NewLimiter(rate.Limit(l.limits.RPS), l.limits.Burst)
+		l.perTenant[tenant] = b
+	}
+	return b
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "testing"
+
+func TestLimiterAllowsBurstThenBlocks(t *testing.T) {
+	l := NewLimiter(RateLimits{RPS: 1, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("tenant-a") {
+			t.Fatalf("request %d within burst was denied", i)
+		}
+	}
+	if l.Allow("tenant-a") {
+		t.Fatalf("request beyond burst was allowed")
+	}
+}
+
+func TestLimiterIsPerTenant(t *testing.T) {
+	l := NewLimiter(RateLimits{RPS: 1, Burst: 1})
+
+	if !l.Allow("tenant-a") {
+		t.Fatalf("first request for tenant-a was denied")
+	}
+	if l.Allow("tenant-a") {
+		t.Fatalf("second immediate request for tenant-a was allowed")
+	}
+	if !l.Allow("tenant-b") {
+		t.Fatalf("tenant-b should have its own independent bucket")
+	}
+}
+
+func TestRetryAfterIsPositiveWhenExhausted(t *testing.T) {
+	l := NewLimiter(RateLimits{RPS: 1, Burst: 1})
+	l.Allow("tenant-a")
+	if d := l.RetryAfter("tenant-a"); d <= 0 {
+		t.Fatalf("RetryAfter() = %v, want > 0 after exhausting the bucket", d)
+	}
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/manifold-inc/endon/ingestor/query"
 )
 
 func safeEnv(env string) string {
@@ -13,3 +21,73 @@ func safeEnv(env string) string {
 	}
 	return res
 }
+
+// writeNDJSON streams records to c as newline-delimited JSON instead
+// of a single JSON array, flushing after each one, so the client can
+// process a large window record by record. The records themselves are
+// already fully held in memory here.
+func writeNDJSON(c echo.Context, records []query.Record) error {
+	c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	c.Response().WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(c.Response())
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		c.Response().Flush()
+	}
+	return nil
+}
+
+// lineProtocol encodes report as an error_logs line-protocol point
+// tagged with the tenant that submitted it and the id that identifies
+// it for GET /errors/:id, so it can be appended to the spool ahead of
+// being written to InfluxDB.
+func lineProtocol(report ErrorReport, tenant, id string, t time.Time) string {
+	var b strings.Builder
+	b.WriteString("error_logs")
+	fmt.Fprintf(&b, ",id=%s", escapeTag(id))
+	fmt.Fprintf(&b, ",tenant=%s", escapeTag(tenant))
+	fmt.Fprintf(&b, ",service=%s", escapeTag(report.Service))
+	fmt.Fprintf(&b, ",endpoint=%s", escapeTag(report.Endpoint))
+	fmt.Fprintf(&b, " error=%s", escapeFieldString(report.Error))
+	if report.Traceback != "" {
+		fmt.Fprintf(&b, ",traceback=%s", escapeFieldString(report.Traceback))
+	}
+	fmt.Fprintf(&b, " %d", t.UnixNano())
+	return b.String()
+}
+
+// tagEscaper escapes the characters that are syntactically meaningful
+// in a line-protocol tag, plus the newlines escapeFieldString also
+// escapes: a client-controlled tag value like service or endpoint can
+// just as easily carry an embedded "\n" as a traceback can, and it
+// would corrupt the spool's newline-delimited segment format the same
+// way.
+var tagEscaper = strings.NewReplacer(
+	",", "\\,",
+	"=", "\\=",
+	" ", "\\ ",
+	"\r\n", "\\n",
+	"\n", "\\n",
+	"\r", "\\n",
+)
+
+func escapeTag(s string) string {
+	return tagEscaper.Replace(s)
+}
+
+func escapeFieldString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	// Line-protocol points are newline-delimited, and so is the
+	// spool's on-disk segment format: a literal newline in a field
+	// like traceback would split one point into several garbled
+	// lines. Escape it to a literal backslash-n instead of stripping
+	// it, so the original text is still recoverable from the field.
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, "\r", `\n`)
+	return `"` + s + `"`
+}
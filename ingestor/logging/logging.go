@@ -0,0 +1,116 @@
+// Package logging provides a small structured-logging interface used
+// throughout endon, along with a default implementation backed by
+// log/slog. Following the pattern the InfluxDB Go client uses for its
+// own logging, the active implementation lives in a package-level
+// variable (Log) that callers and tests can swap out, or disable
+// entirely by assigning nil.
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Logger is implemented by anything that can record leveled,
+// key-value structured log lines. With returns a child logger that
+// attaches additional fields to every subsequent call, without
+// mutating the receiver.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+	With(kv ...any) Logger
+}
+
+// Log is the package-level logger used by default throughout endon.
+// Assign a different Logger to customize output in tests, or nil to
+// disable logging entirely.
+var Log Logger = NewSlogLogger(LevelFromEnv())
+
+// Level controls which calls a Logger actually emits.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+// LevelFromEnv reads LOG_LEVEL ("debug", "info", "warn", "error",
+// "off") and defaults to LevelInfo for anything unset or unrecognized.
+func LevelFromEnv() Level {
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		return LevelDebug
+	case "warn":
+		return LevelWarn
+	case "error":
+		return LevelError
+	case "off":
+		return LevelOff
+	default:
+		return LevelInfo
+	}
+}
+
+// Safe returns l, or a no-op Logger if l is nil. Callers that store a
+// request-scoped child of the package-level Log should build it with
+// Safe(Log).With(...) so a nil Log (logging disabled) doesn't panic
+// downstream handlers.
+func Safe(l Logger) Logger {
+	if l == nil {
+		return noop{}
+	}
+	return l
+}
+
+type noop struct{}
+
+func (noop) Debug(string, ...any) {}
+func (noop) Info(string, ...any)  {}
+func (noop) Warn(string, ...any)  {}
+func (noop) Error(string, ...any) {}
+func (noop) With(...any) Logger   { return noop{} }
+
+// slogLogger is the default Logger implementation: JSON lines on
+// stdout via log/slog, gated by a minimum Level.
+type slogLogger struct {
+	level Level
+	l     *slog.Logger
+}
+
+// NewSlogLogger returns a Logger that emits JSON lines to stdout for
+// any call at or above level. LevelOff returns a no-op Logger instead.
+func NewSlogLogger(level Level) Logger {
+	if level == LevelOff {
+		return noop{}
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: toSlogLevel(level)})
+	return &slogLogger{level: level, l: slog.New(handler)}
+}
+
+func toSlogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (s *slogLogger) Debug(msg string, kv ...any) { s.l.Debug(msg, kv...) }
+func (s *slogLogger) Info(msg string, kv ...any)  { s.l.Info(msg, kv...) }
+func (s *slogLogger) Warn(msg string, kv ...any)  { s.l.Warn(msg, kv...) }
+func (s *slogLogger) Error(msg string, kv ...any) { s.l.Error(msg, kv...) }
+
+func (s *slogLogger) With(kv ...any) Logger {
+	return &slogLogger{level: s.level, l: s.l.With(kv...)}
+}
@@ -0,0 +1,51 @@
+// Package metrics holds the Prometheus collectors shared across the
+// ingest path, so the write path and the HTTP layer can record to the
+// same registry without importing each other.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Registry is a dedicated registry rather than the global default, so
+// /metrics only ever exposes endon's own series.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts every HTTP request handled, by final
+	// status code.
+	RequestsTotal = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "endon_requests_total",
+		Help: "Total HTTP requests handled, by final status code.",
+	}, []string{"status"})
+
+	// InfluxWriteDuration tracks how long each InfluxDB write
+	// attempt (point or batch, first try or retry) takes.
+	InfluxWriteDuration = promauto.With(Registry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "endon_influx_write_duration_seconds",
+		Help:    "Duration of InfluxDB write attempts.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// InfluxWriteErrorsTotal counts InfluxDB write failures by the
+	// classification writer.Classify assigned them.
+	InfluxWriteErrorsTotal = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "endon_influx_write_errors_total",
+		Help: "Total InfluxDB write errors, by error classification.",
+	}, []string{"class"})
+
+	// SpoolBytes reports the on-disk spool's current unacknowledged
+	// byte count.
+	SpoolBytes = promauto.With(Registry).NewGauge(prometheus.GaugeOpts{
+		Name: "endon_spool_bytes",
+		Help: "Bytes currently buffered in the on-disk spool.",
+	})
+
+	// RetryQueueDepth reports how many writes are currently queued
+	// for in-memory retry.
+	RetryQueueDepth = promauto.With(Registry).NewGauge(prometheus.GaugeOpts{
+		Name: "endon_retry_queue_depth",
+		Help: "Number of writes currently queued for retry.",
+	})
+)
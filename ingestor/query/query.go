@@ -0,0 +1,196 @@
+// Package query translates HTTP filter parameters into Flux queries
+// against the error_logs bucket and returns the results as plain maps,
+// ready to be marshalled as JSON or NDJSON.
+package query
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// Defaults and hard caps that protect InfluxDB from unbounded scans.
+const (
+	DefaultSince = 1 * time.Hour
+	MaxRange     = 30 * 24 * time.Hour
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Filters describes a GET /errors request after validation.
+type Filters struct {
+	Service  string
+	Endpoint string
+	Since    time.Time
+	Limit    int
+}
+
+// ParseFilters validates and defaults the query parameters for
+// GET /errors. It returns an error suitable for a 400 response when a
+// parameter is malformed or out of range.
+func ParseFilters(v url.Values) (Filters, error) {
+	f := Filters{
+		Service:  v.Get("service"),
+		Endpoint: v.Get("endpoint"),
+		Since:    time.Now().Add(-DefaultSince),
+		Limit:    DefaultLimit,
+	}
+
+	if raw := v.Get("since"); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return Filters{}, fmt.Errorf("invalid since %q: must be RFC3339: %w", raw, err)
+		}
+		if time.Since(since) > MaxRange {
+			return Filters{}, fmt.Errorf("since %q exceeds max range of %s", raw, MaxRange)
+		}
+		f.Since = since
+	}
+
+	if raw := v.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return Filters{}, fmt.Errorf("invalid limit %q: must be a positive integer", raw)
+		}
+		if limit > MaxLimit {
+			return Filters{}, fmt.Errorf("limit %d exceeds max of %d", limit, MaxLimit)
+		}
+		f.Limit = limit
+	}
+
+	return f, nil
+}
+
+// listFlux builds the Flux query for GET /errors.
+func listFlux(bucket string, f Filters) string {
+	flux := fmt.Sprintf(`from(bucket: "%s")
+  |> range(start: %s)
+  |> filter(fn: (r) => r._measurement == "error_logs")`,
+		bucket, f.Since.UTC().Format(time.RFC3339))
+
+	if f.Service != "" {
+		flux += fmt.Sprintf(`
+  |> filter(fn: (r) => r.service == "%s")`, escape(f.Service))
+	}
+	if f.Endpoint != "" {
+		flux += fmt.Sprintf(`
+  |> filter(fn: (r) => r.endpoint == "%s")`, escape(f.Endpoint))
+	}
+	flux += fmt.Sprintf(`
+  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+  |> group()
+  |> sort(columns: ["_time"], desc: true)
+  |> limit(n: %d)`, f.Limit)
+	return flux
+}
+
+// SummaryGroupBy is the dimension a GET /errors/summary request groups
+// its counts by.
+type SummaryGroupBy string
+
+const (
+	GroupByService  SummaryGroupBy = "service"
+	GroupByEndpoint SummaryGroupBy = "endpoint"
+)
+
+// ParseGroupBy validates the groupBy query parameter.
+func ParseGroupBy(raw string) (SummaryGroupBy, error) {
+	switch SummaryGroupBy(raw) {
+	case GroupByService:
+		return GroupByService, nil
+	case GroupByEndpoint:
+		return GroupByEndpoint, nil
+	default:
+		return "", fmt.Errorf("invalid groupBy %q: must be service or endpoint", raw)
+	}
+}
+
+// ParseWindow validates the window query parameter for
+// GET /errors/summary, capping it at MaxRange.
+func ParseWindow(raw string) (time.Duration, error) {
+	if raw == "" {
+		return DefaultSince, nil
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window %q: must be a positive duration", raw)
+	}
+	if window > MaxRange {
+		return 0, fmt.Errorf("window %q exceeds max range of %s", raw, MaxRange)
+	}
+	return window, nil
+}
+
+func summaryFlux(bucket string, groupBy SummaryGroupBy, window time.Duration) string {
+	return fmt.Sprintf(`from(bucket: "%s")
+  |> range(start: -%s)
+  |> filter(fn: (r) => r._measurement == "error_logs" and r._field == "error")
+  |> group(columns: ["%s"])
+  |> count()
+  |> group()
+  |> sort(columns: ["_value"], desc: true)`, bucket, window.String(), string(groupBy))
+}
+
+func byIDFlux(bucket, id string) string {
+	return fmt.Sprintf(`from(bucket: "%s")
+  |> range(start: -%s)
+  |> filter(fn: (r) => r._measurement == "error_logs")
+  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+  |> filter(fn: (r) => exists r.id and r.id == "%s")
+  |> limit(n: 1)`, bucket, MaxRange.String(), escape(id))
+}
+
+// escape guards against breaking out of a Flux string literal via
+// quote characters in a filter value.
+func escape(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			out = append(out, '\\')
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// Record is a single row of a Flux result, flattened to its column
+// values for JSON marshalling.
+type Record map[string]interface{}
+
+// List runs GET /errors and returns matching records, most recent
+// first.
+func List(ctx context.Context, q api.QueryAPI, bucket string, f Filters) ([]Record, error) {
+	return run(ctx, q, listFlux(bucket, f))
+}
+
+// Summary runs GET /errors/summary and returns one record per group,
+// with the group's count in its "_value" field.
+func Summary(ctx context.Context, q api.QueryAPI, bucket string, groupBy SummaryGroupBy, window time.Duration) ([]Record, error) {
+	return run(ctx, q, summaryFlux(bucket, groupBy, window))
+}
+
+// ByID runs GET /errors/{id} and returns at most one record.
+func ByID(ctx context.Context, q api.QueryAPI, bucket, id string) ([]Record, error) {
+	return run(ctx, q, byIDFlux(bucket, id))
+}
+
+func run(ctx context.Context, q api.QueryAPI, flux string) ([]Record, error) {
+	result, err := q.Query(ctx, flux)
+	if err != nil {
+		return nil, fmt.Errorf("flux query failed: %w", err)
+	}
+	defer result.Close()
+
+	var records []Record
+	for result.Next() {
+		records = append(records, Record(result.Record().Values()))
+	}
+	if result.Err() != nil {
+		return nil, fmt.Errorf("flux query failed: %w", result.Err())
+	}
+	return records, nil
+}
@@ -0,0 +1,90 @@
+package query
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseFiltersDefaults(t *testing.T) {
+	f, err := ParseFilters(url.Values{})
+	if err != nil {
+		t.Fatalf("ParseFilters: %v", err)
+	}
+	if f.Limit != DefaultLimit {
+		t.Errorf("Limit = %d, want default %d", f.Limit, DefaultLimit)
+	}
+	if f.Since.After(time.Now().Add(-DefaultSince + time.Second)) {
+		t.Errorf("Since = %v, want roughly now - %v", f.Since, DefaultSince)
+	}
+}
+
+func TestParseFiltersRejectsBadSince(t *testing.T) {
+	v := url.Values{"since": {"not-a-time"}}
+	if _, err := ParseFilters(v); err == nil {
+		t.Fatal("expected an error for a malformed since, got nil")
+	}
+}
+
+func TestParseFiltersRejectsSinceBeyondMaxRange(t *testing.T) {
+	v := url.Values{"since": {time.Now().Add(-MaxRange - time.Hour).Format(time.RFC3339)}}
+	if _, err := ParseFilters(v); err == nil {
+		t.Fatal("expected an error for a since beyond MaxRange, got nil")
+	}
+}
+
+func TestParseFiltersRejectsBadLimit(t *testing.T) {
+	cases := []string{"0", "-1", "not-a-number"}
+	for _, raw := range cases {
+		if _, err := ParseFilters(url.Values{"limit": {raw}}); err == nil {
+			t.Errorf("limit=%q: expected an error, got nil", raw)
+		}
+	}
+}
+
+func TestParseFiltersRejectsLimitBeyondMax(t *testing.T) {
+	v := url.Values{"limit": {"100000"}}
+	if _, err := ParseFilters(v); err == nil {
+		t.Fatal("expected an error for a limit beyond MaxLimit, got nil")
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    SummaryGroupBy
+		wantErr bool
+	}{
+		{"service", GroupByService, false},
+		{"endpoint", GroupByEndpoint, false},
+		{"bogus", "", true},
+		{"", "", true},
+	}
+	for _, c := range cases {
+		got, err := ParseGroupBy(c.raw)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseGroupBy(%q) error = %v, wantErr %v", c.raw, err, c.wantErr)
+		}
+		if got != c.want {
+			t.Errorf("ParseGroupBy(%q) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	if got, err := ParseWindow(""); err != nil || got != DefaultSince {
+		t.Errorf("ParseWindow(\"\") = %v, %v, want %v, nil", got, err, DefaultSince)
+	}
+	if got, err := ParseWindow("2h"); err != nil || got != 2*time.Hour {
+		t.Errorf("ParseWindow(\"2h\") = %v, %v, want %v, nil", got, err, 2*time.Hour)
+	}
+	if _, err := ParseWindow("not-a-duration"); err == nil {
+		t.Error("ParseWindow(\"not-a-duration\"): expected an error, got nil")
+	}
+	if _, err := ParseWindow("0h"); err == nil {
+		t.Error("ParseWindow(\"0h\"): expected an error for a non-positive window, got nil")
+	}
+	if _, err := ParseWindow((MaxRange + time.Hour).String()); err == nil {
+		t.Error("ParseWindow beyond MaxRange: expected an error, got nil")
+	}
+}
@@ -3,36 +3,36 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aidarkhanov/nanoid"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/manifold-inc/endon/ingestor/auth"
+	"github.com/manifold-inc/endon/ingestor/logging"
+	"github.com/manifold-inc/endon/ingestor/metrics"
+	"github.com/manifold-inc/endon/ingestor/query"
+	"github.com/manifold-inc/endon/ingestor/spool"
+	"github.com/manifold-inc/endon/ingestor/telemetry"
+	"github.com/manifold-inc/endon/ingestor/writer"
 )
 
-var Reset = "\033[0m"
-var Red = "\033[31m"
-var Green = "\033[32m"
-var Yellow = "\033[33m"
-var Blue = "\033[34m"
-var Purple = "\033[35m"
-var Cyan = "\033[36m"
-var Gray = "\033[37m"
-var White = "\033[97m"
 var organizationID string
 
 type Context struct {
 	echo.Context
-	Info  *log.Logger
-	Warn  *log.Logger
-	Err   *log.Logger
-	reqid string
+	Log    logging.Logger
+	Tenant auth.Token
 }
 
 type ErrorReport struct {
@@ -48,15 +48,42 @@ func main() {
 	ORG := safeEnv("DOCKER_INFLUXDB_ORGANIZATION")
 	BUCKET := safeEnv("DOCKER_INFLUXDB_BUCKET")
 
+	shutdownTracing, err := telemetry.Init(context.Background())
+	if err != nil {
+		log.Fatalf("Cannot start server without tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	e := echo.New()
 	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := telemetry.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			ctx, span := telemetry.Tracer().Start(ctx, req.Method+" "+c.Path())
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+
 			reqId, _ := nanoid.Generate("0123456789abcdefghijklmnopqrstuvwxyz", 12)
-			InfoLog := log.New(os.Stdout, fmt.Sprintf("%sINFO [%s]: %s", Green, reqId, Reset), log.Ldate|log.Ltime|log.Lshortfile)
-			WarnLog := log.New(os.Stdout, fmt.Sprintf("%sWARNING [%s]: %s", Yellow, reqId, Reset), log.Ldate|log.Ltime|log.Lshortfile)
-			ErrLog := log.New(os.Stdout, fmt.Sprintf("%sERROR [%s]: %s", Red, reqId, Reset), log.Ldate|log.Ltime|log.Lshortfile)
-			cc := &Context{c, InfoLog, WarnLog, ErrLog, reqId}
-			return next(cc)
+			traceID, spanID := telemetry.IDs(ctx)
+			span.SetAttributes(attribute.String("req_id", reqId))
+			reqLog := logging.Safe(logging.Log).With(
+				"req_id", reqId,
+				"trace_id", traceID,
+				"span_id", spanID,
+				"method", req.Method,
+				"path", req.URL.Path,
+				"remote_ip", c.RealIP(),
+			)
+			cc := &Context{Context: c, Log: reqLog}
+
+			err := next(cc)
+			if err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			metrics.RequestsTotal.WithLabelValues(strconv.Itoa(status)).Inc()
+			return err
 		}
 	})
 
@@ -65,72 +92,228 @@ func main() {
 
 	org, err := client.OrganizationsAPI().FindOrganizationByName(context.Background(), ORG)
 	if err != nil {
-		log.Printf("%sERROR: Failed to lookup organization named %q: %v%s", Red, ORG, err, Reset)
+		log.Printf("ERROR: Failed to lookup organization named %q: %v", ORG, err)
 		log.Fatal("Cannot start server without InfluxDB organization access")
 	}
 	log.Printf("Organization found: %+v\n", org)
 	organizationID = *org.Id
 	log.Printf("Organization ID: %s\n", organizationID)
 
-	writeAPI := client.WriteAPIBlocking(ORG, BUCKET)
+	w := writer.New(client.WriteAPIBlocking(ORG, BUCKET), writer.ConfigFromEnv())
+	w.Start()
 
-	e.POST("/", func(c echo.Context) error {
+	sp, err := spool.Open(spool.ConfigFromEnv(), func(ctx context.Context, lines []string) error {
+		return w.WriteLines(ctx, lines)
+	})
+	if err != nil {
+		log.Fatalf("Cannot start server without a usable spool: %v", err)
+	}
+	sp.Start()
+
+	tokens, err := auth.StoreFromEnv()
+	if err != nil {
+		log.Fatalf("Cannot start server without a usable token store: %v", err)
+	}
+	limiter := auth.NewLimiter(auth.RateLimitsFromEnv())
+
+	requireToken := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cc := c.(*Context)
+
+			header := c.Request().Header.Get("Authorization")
+			const prefix = "Bearer "
+			if !strings.HasPrefix(header, prefix) {
+				return c.JSON(http.StatusUnauthorized, "Missing or malformed Authorization header")
+			}
+
+			t, ok := tokens.Lookup(strings.TrimPrefix(header, prefix))
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, "Invalid token")
+			}
+
+			if !limiter.Allow(t.Tenant) {
+				retryAfter := limiter.RetryAfter(t.Tenant)
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				return c.JSON(http.StatusTooManyRequests, "Rate limit exceeded")
+			}
+
+			cc.Tenant = t
+			cc.Log = cc.Log.With("tenant", t.Tenant)
+			return next(cc)
+		}
+	}
+
+	requireAdmin := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cc := c.(*Context)
+			if !cc.Tenant.Admin {
+				return c.JSON(http.StatusForbidden, "Admin token required")
+			}
+			return next(c)
+		}
+	}
+
+	protected := e.Group("")
+	protected.Use(requireToken)
+
+	protected.POST("/", func(c echo.Context) error {
 		cc := c.(*Context)
 
 		// Add Content-Type validation
 		if c.Request().Header.Get("Content-Type") != "application/json" {
-			cc.Err.Printf("Invalid Content-Type. Expected application/json, got %s", c.Request().Header.Get("Content-Type"))
+			cc.Log.Warn("invalid content-type", "content_type", c.Request().Header.Get("Content-Type"))
 			return c.JSON(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
 		}
 
-		cc.Info.Printf("Attempting ingestion to DB\n")
+		cc.Log.Info("ingesting error report")
 
 		// Read request body
 		body, err := io.ReadAll(c.Request().Body)
 		if err != nil {
-			cc.Err.Printf("Error reading request body: %v", err)
+			cc.Log.Error("reading request body", "error", err)
 			return c.JSON(http.StatusBadRequest, "Error reading request body")
 		}
 
 		var report ErrorReport
 		if err := json.Unmarshal(body, &report); err != nil {
-			cc.Err.Printf("Error unmarshalling JSON: %v", err)
+			cc.Log.Error("unmarshalling json", "error", err)
 			return c.JSON(http.StatusBadRequest, "Error unmarshalling JSON")
 		}
 
 		if report.Service == "" || report.Endpoint == "" || report.Error == "" {
-			cc.Err.Printf("Missing required fields in the JSON payload")
+			cc.Log.Warn("missing required fields in json payload")
 			return c.JSON(http.StatusBadRequest, "Missing required fields in the JSON payload")
 		}
 
-		// Create fields map with required error field
-		fields := map[string]interface{}{
-			"error": report.Error,
+		trace.SpanFromContext(c.Request().Context()).SetAttributes(
+			attribute.String("service", report.Service),
+			attribute.String("endpoint", report.Endpoint),
+		)
+
+		if !cc.Tenant.Allows(report.Service) {
+			cc.Log.Warn("service not allowed for tenant", "service", report.Service)
+			return c.JSON(http.StatusForbidden, "Service not allowed for this token")
 		}
 
-		// Only add traceback if it's not empty
-		if report.Traceback != "" {
-			fields["traceback"] = report.Traceback
+		id, _ := nanoid.Generate("0123456789abcdefghijklmnopqrstuvwxyz", 12)
+
+		// Append to the durable spool instead of writing InfluxDB
+		// directly, so a crash or an outage between here and the
+		// background flush can't lose the point.
+		line := lineProtocol(report, cc.Tenant.Tenant, id, time.Now())
+		if err := sp.Append(c.Request().Context(), line); err != nil {
+			cc.Log.Error("spooling point", "error", err)
+			return c.JSON(http.StatusInternalServerError, "Error spooling point")
 		}
 
-		// Create the Influx DB point
-		point := write.NewPoint(
-			"error_logs",
-			map[string]string{
-				"service":  report.Service,
-				"endpoint": report.Endpoint,
-			},
-			fields,
-			time.Now(),
-		)
+		return cc.JSON(http.StatusOK, map[string]string{"status": "Error logged", "id": id})
+	})
+
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})))
+
+	e.GET("/healthz", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"spool_bytes_buffered": sp.BufferedBytes(),
+			"spool_oldest_unacked": sp.OldestUnackedAge().String(),
+			"spool_dropped_total":  sp.DroppedCount(),
+		})
+	})
+
+	queryAPI := client.QueryAPI(ORG)
 
-		// Write point asynchronously
-		if err := writeAPI.WritePoint(context.Background(), point); err != nil {
-			cc.Err.Printf("Error writing point to InfluxDB: %v", err)
-			return c.JSON(http.StatusInternalServerError, "Error writing point to InfluxDB")
+	protected.GET("/errors", func(c echo.Context) error {
+		cc := c.(*Context)
+
+		filters, err := query.ParseFilters(c.QueryParams())
+		if err != nil {
+			cc.Log.Warn("rejecting /errors request", "error", err)
+			return c.JSON(http.StatusBadRequest, err.Error())
 		}
 
-		return cc.JSON(http.StatusOK, "Error logged")
+		records, err := query.List(c.Request().Context(), queryAPI, BUCKET, filters)
+		if err != nil {
+			cc.Log.Error("querying influxdb", "error", err)
+			return c.JSON(http.StatusInternalServerError, "Error querying InfluxDB")
+		}
+
+		if c.Request().Header.Get("Accept") == "application/x-ndjson" {
+			return writeNDJSON(c, records)
+		}
+		return c.JSON(http.StatusOK, records)
+	})
+
+	protected.GET("/errors/summary", func(c echo.Context) error {
+		cc := c.(*Context)
+
+		groupBy, err := query.ParseGroupBy(c.QueryParam("groupBy"))
+		if err != nil {
+			cc.Log.Warn("rejecting /errors/summary request", "error", err)
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		window, err := query.ParseWindow(c.QueryParam("window"))
+		if err != nil {
+			cc.Log.Warn("rejecting /errors/summary request", "error", err)
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+
+		records, err := query.Summary(c.Request().Context(), queryAPI, BUCKET, groupBy, window)
+		if err != nil {
+			cc.Log.Error("querying influxdb", "error", err)
+			return c.JSON(http.StatusInternalServerError, "Error querying InfluxDB")
+		}
+		return c.JSON(http.StatusOK, records)
+	})
+
+	protected.GET("/errors/:id", func(c echo.Context) error {
+		cc := c.(*Context)
+
+		id := c.Param("id")
+		if id == "" {
+			return c.JSON(http.StatusBadRequest, "Missing error id")
+		}
+
+		records, err := query.ByID(c.Request().Context(), queryAPI, BUCKET, id)
+		if err != nil {
+			cc.Log.Error("querying influxdb", "error", err)
+			return c.JSON(http.StatusInternalServerError, "Error querying InfluxDB")
+		}
+		if len(records) == 0 {
+			return c.JSON(http.StatusNotFound, "Error log not found")
+		}
+		return c.JSON(http.StatusOK, records[0])
+	})
+
+	admin := protected.Group("/admin/tokens")
+	admin.Use(requireAdmin)
+
+	admin.GET("", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, tokens.List())
+	})
+
+	admin.POST("", func(c echo.Context) error {
+		cc := c.(*Context)
+
+		var t auth.Token
+		if err := json.NewDecoder(c.Request().Body).Decode(&t); err != nil {
+			cc.Log.Warn("rejecting /admin/tokens request", "error", err)
+			return c.JSON(http.StatusBadRequest, "Error unmarshalling JSON")
+		}
+		if t.Token == "" || t.Tenant == "" {
+			return c.JSON(http.StatusBadRequest, "token and tenant are required")
+		}
+
+		tokens.Put(t)
+		cc.Log.Info("token registered", "tenant", t.Tenant)
+		return c.JSON(http.StatusCreated, t)
 	})
+
+	admin.DELETE("/:token", func(c echo.Context) error {
+		cc := c.(*Context)
+
+		tokens.Remove(c.Param("token"))
+		cc.Log.Info("token revoked")
+		return c.NoContent(http.StatusNoContent)
+	})
+
 	e.Logger.Fatal(e.Start(":80"))
 }
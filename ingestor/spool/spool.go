@@ -0,0 +1,497 @@
+// Package spool durably buffers InfluxDB line-protocol points on disk
+// so a crash or an InfluxDB outage doesn't lose data that only ever
+// made it as far as an in-memory batch. Points are appended to
+// rotating segment files; a background loop batches them off disk and
+// hands them to a Sink, advancing a checkpoint as batches are
+// acknowledged.
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manifold-inc/endon/ingestor/metrics"
+	"github.com/manifold-inc/endon/ingestor/telemetry"
+)
+
+// Sink flushes a batch of line-protocol lines to InfluxDB (or
+// wherever they're ultimately headed). It should itself retry
+// transient failures; any error it returns leaves the batch
+// unacknowledged so it is retried from the same checkpoint.
+type Sink func(ctx context.Context, lines []string) error
+
+// Config holds the spool's tunables.
+type Config struct {
+	Dir             string
+	MaxSegmentBytes int64
+	BatchSize       int
+	FlushInterval   time.Duration
+	MaxSpoolBytes   int64
+}
+
+// DefaultConfig matches the sizes called out in the backlog request:
+// 8 MiB segments, 5000-line/1s batches.
+var DefaultConfig = Config{
+	Dir:             "spool",
+	MaxSegmentBytes: 8 * 1024 * 1024,
+	BatchSize:       5000,
+	FlushInterval:   time.Second,
+	MaxSpoolBytes:   512 * 1024 * 1024,
+}
+
+// ConfigFromEnv builds a Config from env vars, falling back to
+// DefaultConfig for anything unset or unparsable.
+//
+//   - SPOOL_DIR
+//   - SPOOL_MAX_SEGMENT_BYTES
+//   - SPOOL_BATCH_SIZE
+//   - SPOOL_FLUSH_INTERVAL_MS
+//   - SPOOL_MAX_BYTES
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig
+	if v, ok := os.LookupEnv("SPOOL_DIR"); ok && v != "" {
+		cfg.Dir = v
+	}
+	if v, ok := envInt64("SPOOL_MAX_SEGMENT_BYTES"); ok {
+		cfg.MaxSegmentBytes = v
+	}
+	if v, ok := envInt64("SPOOL_BATCH_SIZE"); ok {
+		cfg.BatchSize = int(v)
+	}
+	if v, ok := envInt64("SPOOL_FLUSH_INTERVAL_MS"); ok {
+		cfg.FlushInterval = time.Duration(v) * time.Millisecond
+	}
+	if v, ok := envInt64("SPOOL_MAX_BYTES"); ok {
+		cfg.MaxSpoolBytes = v
+	}
+	return cfg
+}
+
+func envInt64(name string) (int64, bool) {
+	raw, present := os.LookupEnv(name)
+	if !present {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		log.Printf("spool: ignoring invalid %s=%q: %v", name, raw, err)
+		return 0, false
+	}
+	return v, true
+}
+
+const segmentPrefix = "segment-"
+const checkpointFile = "checkpoint.json"
+
+// checkpoint records how far the flush loop has durably acknowledged.
+type checkpoint struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// Spool durably buffers line-protocol points on disk ahead of a Sink.
+// The zero value is not usable; construct with Open.
+type Spool struct {
+	cfg  Config
+	sink Sink
+
+	mu          sync.Mutex
+	writeFile   *os.File
+	writeSeg    int
+	writeBytes  int64
+	bufferBytes int64
+
+	checkpoint    checkpoint
+	oldestUnacked atomic.Int64 // unix nanos when the spool last stopped being caught up, 0 if caught up
+
+	dropped uint64
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// Open prepares the spool directory, replaying any unacknowledged
+// segments left over from a previous run, and returns a Spool ready
+// to accept Append calls. Call Start to begin flushing to sink.
+func Open(cfg Config, sink Sink) (*Spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool: creating dir %s: %w", cfg.Dir, err)
+	}
+
+	s := &Spool{
+		cfg:  cfg,
+		sink: sink,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if err := s.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	segments, err := s.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	writeSeg := 0
+	if len(segments) > 0 {
+		writeSeg = segments[len(segments)-1]
+	}
+	if err := s.openWriteSegment(writeSeg); err != nil {
+		return nil, err
+	}
+
+	s.recomputeBufferBytes(segments)
+	if s.bufferBytes > 0 {
+		s.oldestUnacked.Store(time.Now().UnixNano())
+	}
+
+	return s, nil
+}
+
+// Start launches the background goroutine that batches segments off
+// disk and flushes them to the configured Sink.
+func (s *Spool) Start() {
+	go s.flushLoop()
+}
+
+// Close stops the flush loop and closes the active segment file.
+func (s *Spool) Close() error {
+	close(s.quit)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeFile.Close()
+}
+
+// Append durably buffers a single line-protocol point. It never
+// blocks on InfluxDB: the point is fsynced to the spool directory and
+// picked up by the background flush loop. If the spool is already at
+// MaxSpoolBytes, the point is dropped and counted instead of growing
+// the spool without bound.
+//
+// ctx is used only to start a span for the durable write itself, as a
+// child of the caller's ingest span; the later batched flush mixes
+// lines from many different Append calls and so gets its own
+// independent span rather than a borrowed one.
+func (s *Spool) Append(ctx context.Context, line string) error {
+	_, span := telemetry.Tracer().Start(ctx, "spool.append")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bufferBytes+int64(len(line))+1 > s.cfg.MaxSpoolBytes {
+		atomic.AddUint64(&s.dropped, 1)
+		return nil
+	}
+
+	if s.writeBytes >= s.cfg.MaxSegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := fmt.Fprintln(s.writeFile, line)
+	if err != nil {
+		return fmt.Errorf("spool: appending to segment %d: %w", s.writeSeg, err)
+	}
+	if err := s.writeFile.Sync(); err != nil {
+		return fmt.Errorf("spool: syncing segment %d: %w", s.writeSeg, err)
+	}
+
+	s.writeBytes += int64(n)
+	s.bufferBytes += int64(n)
+	metrics.SpoolBytes.Set(float64(s.bufferBytes))
+	s.oldestUnacked.CompareAndSwap(0, time.Now().UnixNano())
+	return nil
+}
+
+func (s *Spool) rotateLocked() error {
+	if err := s.writeFile.Close(); err != nil {
+		return fmt.Errorf("spool: closing segment %d: %w", s.writeSeg, err)
+	}
+	return s.openWriteSegment(s.writeSeg + 1)
+}
+
+func (s *Spool) openWriteSegment(idx int) error {
+	f, err := os.OpenFile(s.segmentPath(idx), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("spool: opening segment %d: %w", idx, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("spool: stat segment %d: %w", idx, err)
+	}
+	s.writeFile = f
+	s.writeSeg = idx
+	s.writeBytes = info.Size()
+	return nil
+}
+
+func (s *Spool) segmentPath(idx int) string {
+	return filepath.Join(s.cfg.Dir, fmt.Sprintf("%s%010d.log", segmentPrefix, idx))
+}
+
+func (s *Spool) checkpointPath() string {
+	return filepath.Join(s.cfg.Dir, checkpointFile)
+}
+
+func (s *Spool) loadCheckpoint() error {
+	data, err := os.ReadFile(s.checkpointPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("spool: reading checkpoint: %w", err)
+	}
+	return json.Unmarshal(data, &s.checkpoint)
+}
+
+func (s *Spool) saveCheckpoint() error {
+	data, err := json.Marshal(s.checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := s.checkpointPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("spool: writing checkpoint: %w", err)
+	}
+	return os.Rename(tmp, s.checkpointPath())
+}
+
+func (s *Spool) listSegments() ([]int, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("spool: listing %s: %w", s.cfg.Dir, err)
+	}
+	var segments []int
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), segmentPrefix) {
+			continue
+		}
+		idxStr := strings.TrimSuffix(strings.TrimPrefix(e.Name(), segmentPrefix), ".log")
+		idx, err := strconv.Atoi(idxStr)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, idx)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (s *Spool) recomputeBufferBytes(segments []int) {
+	var total int64
+	for _, idx := range segments {
+		info, err := os.Stat(s.segmentPath(idx))
+		if err != nil {
+			continue
+		}
+		if idx == s.checkpoint.Segment {
+			total += info.Size() - s.checkpoint.Offset
+			continue
+		}
+		if idx > s.checkpoint.Segment {
+			total += info.Size()
+		}
+	}
+	s.bufferBytes = total
+}
+
+// BufferedBytes returns the number of unacknowledged bytes currently
+// held in the spool.
+func (s *Spool) BufferedBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bufferBytes
+}
+
+// OldestUnackedAge returns how long the spool has held unacknowledged
+// data without being fully caught up, or 0 if it is caught up. The
+// clock starts at the first Append after the spool was last caught up
+// (or at Open, for data replayed from a previous run) and is not moved
+// forward as older lines are acknowledged while newer ones remain, so
+// under steady load it can exceed the actual age of the oldest
+// unacknowledged write.
+func (s *Spool) OldestUnackedAge() time.Duration {
+	ts := s.oldestUnacked.Load()
+	if ts == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, ts))
+}
+
+// DroppedCount returns the number of points dropped because the spool
+// had already reached MaxSpoolBytes.
+func (s *Spool) DroppedCount() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Spool) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			if err := s.flushOnce(context.Background()); err != nil {
+				log.Printf("spool: flush failed, will retry: %v", err)
+			}
+		}
+	}
+}
+
+// flushOnce reads one batch starting at the checkpoint, hands it to
+// the sink, and advances the checkpoint on success. A batch can mix
+// lines appended by many different requests, so its span is a root of
+// its own rather than a child of any one of them.
+func (s *Spool) flushOnce(ctx context.Context) error {
+	s.mu.Lock()
+	seg := s.checkpoint.Segment
+	offset := s.checkpoint.Offset
+	s.mu.Unlock()
+
+	lines, nextSeg, nextOffset, readErr := s.readBatch(seg, offset, s.cfg.BatchSize)
+	if len(lines) == 0 {
+		if readErr == nil {
+			s.mu.Lock()
+			if s.bufferBytes == 0 {
+				s.oldestUnacked.Store(0)
+			}
+			s.mu.Unlock()
+		}
+		return readErr
+	}
+
+	ctx, span := telemetry.Tracer().Start(ctx, "spool.flush")
+	sinkErr := s.sink(ctx, lines)
+	span.End()
+	if sinkErr != nil {
+		return fmt.Errorf("sink: %w", sinkErr)
+	}
+
+	s.mu.Lock()
+	s.checkpoint.Segment = nextSeg
+	s.checkpoint.Offset = nextOffset
+	segments, listErr := s.listSegments()
+	if listErr == nil {
+		s.recomputeBufferBytes(segments)
+	}
+	if s.bufferBytes <= 0 {
+		s.bufferBytes = 0
+		s.oldestUnacked.Store(0)
+	} else {
+		s.oldestUnacked.CompareAndSwap(0, time.Now().UnixNano())
+	}
+	metrics.SpoolBytes.Set(float64(s.bufferBytes))
+	err := s.saveCheckpoint()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if listErr != nil {
+		return listErr
+	}
+
+	return s.pruneConsumedSegments(nextSeg)
+}
+
+// readBatch reads up to n lines starting at (seg, offset), rolling
+// over into later segments once the current one has been fully
+// consumed, and returns the lines plus the segment and offset to
+// resume from next time. A segment is only ever rolled past once a
+// later segment already exists on disk, so the live write segment is
+// never mistaken for an exhausted one.
+func (s *Spool) readBatch(seg int, offset int64, n int) ([]string, int, int64, error) {
+	var lines []string
+	for len(lines) < n {
+		batch, pos, err := s.readSegment(seg, offset, n-len(lines))
+		if err != nil {
+			return lines, seg, offset, err
+		}
+		lines = append(lines, batch...)
+		if len(batch) > 0 {
+			offset = pos
+			continue
+		}
+
+		s.mu.Lock()
+		isLive := seg == s.writeSeg
+		s.mu.Unlock()
+		if isLive {
+			break
+		}
+		if _, err := os.Stat(s.segmentPath(seg + 1)); err != nil {
+			break
+		}
+		seg++
+		offset = 0
+	}
+	return lines, seg, offset, nil
+}
+
+// readSegment reads up to n lines from segment seg starting at offset
+// and returns the lines plus the offset within that segment after the
+// read.
+func (s *Spool) readSegment(seg int, offset int64, n int) ([]string, int64, error) {
+	f, err := os.Open(s.segmentPath(seg))
+	if os.IsNotExist(err) {
+		return nil, offset, nil
+	}
+	if err != nil {
+		return nil, offset, fmt.Errorf("spool: opening segment %d: %w", seg, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return nil, offset, fmt.Errorf("spool: seeking segment %d: %w", seg, err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	pos := offset
+	for len(lines) < n && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		pos += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return lines, pos, fmt.Errorf("spool: reading segment %d: %w", seg, err)
+	}
+	return lines, pos, nil
+}
+
+// pruneConsumedSegments deletes any segment strictly older than the
+// one the checkpoint now points at, since it has been fully
+// acknowledged and will never be read again.
+func (s *Spool) pruneConsumedSegments(upTo int) error {
+	segments, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	for _, idx := range segments {
+		if idx >= upTo {
+			continue
+		}
+		if err := os.Remove(s.segmentPath(idx)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("spool: removing consumed segment %d: %w", idx, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Package telemetry wires up OpenTelemetry tracing for the ingest
+// path: a global text-map propagator so traceparent headers are
+// honored, and (when OTEL_EXPORTER_OTLP_ENDPOINT is configured) an
+// OTLP/HTTP exporter. A TracerProvider is always installed, so spans
+// always get valid trace/span IDs for log correlation; with no
+// endpoint set, those spans simply aren't exported off-box.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/manifold-inc/endon/ingestor"
+
+// Init installs a global propagator and tracer provider for the
+// ingest path. It returns a shutdown func that flushes and stops any
+// exporter; call it during server shutdown.
+func Init(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	res, err := sdkresource.New(ctx, sdkresource.WithAttributes(semconv.ServiceName("endon")))
+	if err != nil {
+		return nil, fmt.Errorf("telemetry: building resource: %w", err)
+	}
+
+	endpoint, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if !ok || endpoint == "" {
+		// No collector configured. Install a real TracerProvider
+		// anyway, rather than leaving the SDK default no-op one in
+		// place: without it, spans started here get no IDs of their
+		// own, and IDs below returns empty strings unless the request
+		// carried a traceparent.
+		tp := sdktrace.NewTracerProvider(sdktrace.WithResource(res))
+		otel.SetTracerProvider(tp)
+		return tp.Shutdown, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("telemetry: creating OTLP exporter: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer used throughout the ingest path.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Extract pulls a remote span context out of carrier (typically
+// request headers) per the traceparent convention, for starting a
+// span that's a child of the caller's.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// IDs returns the trace and span IDs active on ctx, for attaching to
+// log lines. Both are empty if ctx carries no span.
+func IDs(ctx context.Context) (traceID, spanID string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", ""
+	}
+	return sc.TraceID().String(), sc.SpanID().String()
+}
@@ -0,0 +1,90 @@
+package writer
+
+import (
+	"errors"
+	"strings"
+
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+)
+
+// ErrorClass is the outcome of classifying a write error: whether the
+// point should be retried, dropped, or treated as already handled.
+type ErrorClass int
+
+const (
+	// ClassTransient errors are worth retrying: network timeouts,
+	// 5xx responses, 429s, and a server still draining its hinted
+	// handoff queue.
+	ClassTransient ErrorClass = iota
+	// ClassPermanent errors will never succeed on retry: bad
+	// requests, unknown buckets, unparsable line protocol.
+	ClassPermanent
+	// ClassIgnorable responses are not really failures: the point
+	// landed outside the bucket's retention policy, or only part of
+	// a batch failed.
+	ClassIgnorable
+)
+
+// Classify inspects err, which is the error returned by an InfluxDB
+// write call, and decides how the caller should react to it.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ClassIgnorable
+	}
+
+	msg := strings.ToLower(err.Error())
+
+	// Checked before the status code, since InfluxDB reports these
+	// with a 4xx status that would otherwise read as permanent.
+	if containsAny(msg, "partial write", "points beyond retention policy dropped") {
+		return ClassIgnorable
+	}
+
+	var apiErr *ihttp.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == 429:
+			return ClassTransient
+		case apiErr.StatusCode >= 500:
+			return ClassTransient
+		case apiErr.StatusCode >= 400:
+			return ClassPermanent
+		}
+	}
+
+	switch {
+	case containsAny(msg, "hinted handoff queue not empty", "timeout", "connection refused",
+		"connection reset", "eof", "temporary failure", "too many requests"):
+		return ClassTransient
+	case containsAny(msg, "database not found", "bucket not found", "unable to parse",
+		"bad request", "invalid"):
+		return ClassPermanent
+	}
+
+	// Unknown errors are treated as transient: we'd rather retry a
+	// point a few extra times than silently lose it.
+	return ClassTransient
+}
+
+// String renders the class for use as a metric label.
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassTransient:
+		return "transient"
+	case ClassPermanent:
+		return "permanent"
+	case ClassIgnorable:
+		return "ignorable"
+	default:
+		return "unknown"
+	}
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
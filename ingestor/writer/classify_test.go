@@ -0,0 +1,32 @@
+package writer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"nil is ignorable", nil, ClassIgnorable},
+		{"timeout is transient", errors.New("context deadline exceeded: timeout"), ClassTransient},
+		{"connection refused is transient", errors.New("dial tcp: connection refused"), ClassTransient},
+		{"too many requests is transient", errors.New("429 Too Many Requests"), ClassTransient},
+		{"hinted handoff is transient", errors.New("hinted handoff queue not empty"), ClassTransient},
+		{"bad request is permanent", errors.New("400 Bad Request: unable to parse line protocol"), ClassPermanent},
+		{"unknown bucket is permanent", errors.New("bucket not found"), ClassPermanent},
+		{"partial write is ignorable", errors.New("partial write: points beyond retention policy dropped"), ClassIgnorable},
+		{"unrecognized error defaults to transient", errors.New("something went sideways"), ClassTransient},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Classify(c.err); got != c.want {
+				t.Errorf("Classify(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Package writer wraps an InfluxDB blocking write API with a bounded
+// in-memory retry queue, so a transient write failure doesn't lose a
+// point (or a spooled batch of line-protocol lines) and its caller
+// only waits for the first attempt, never for retries. When the queue
+// is full, its oldest entries are evicted.
+package writer
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/manifold-inc/endon/ingestor/metrics"
+	"github.com/manifold-inc/endon/ingestor/telemetry"
+)
+
+// Config holds the tunables for the retry queue. All fields can be
+// sourced from the environment with ConfigFromEnv.
+type Config struct {
+	QueueSize   int
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxElapsed  time.Duration
+}
+
+// DefaultConfig matches the values called out in the backlog request:
+// base 250ms, factor 2, max 30s, max elapsed 15m. MaxAttempts is set
+// high enough (jittered delays at the 30s cap average ~22.5s, so ~40
+// of them fit in 15m, plus the ramp to the cap) that MaxElapsed, not
+// MaxAttempts, is always the constraint that actually drops a point.
+var DefaultConfig = Config{
+	QueueSize:   1000,
+	MaxAttempts: 50,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	MaxElapsed:  15 * time.Minute,
+}
+
+// ConfigFromEnv builds a Config from env vars, falling back to
+// DefaultConfig for anything unset or unparsable.
+//
+//   - RETRY_QUEUE_SIZE
+//   - RETRY_MAX_ATTEMPTS
+//   - RETRY_BASE_DELAY_MS
+//   - RETRY_MAX_DELAY_MS
+//   - RETRY_MAX_ELAPSED_MINUTES
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig
+	if v, ok := envInt("RETRY_QUEUE_SIZE"); ok {
+		cfg.QueueSize = v
+	}
+	if v, ok := envInt("RETRY_MAX_ATTEMPTS"); ok {
+		cfg.MaxAttempts = v
+	}
+	if v, ok := envInt("RETRY_BASE_DELAY_MS"); ok {
+		cfg.BaseDelay = time.Duration(v) * time.Millisecond
+	}
+	if v, ok := envInt("RETRY_MAX_DELAY_MS"); ok {
+		cfg.MaxDelay = time.Duration(v) * time.Millisecond
+	}
+	if v, ok := envInt("RETRY_MAX_ELAPSED_MINUTES"); ok {
+		cfg.MaxElapsed = time.Duration(v) * time.Minute
+	}
+	return cfg
+}
+
+func envInt(name string) (int, bool) {
+	raw, present := os.LookupEnv(name)
+	if !present {
+		return 0, false
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("writer: ignoring invalid %s=%q: %v", name, raw, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// payload is anything a job can retry: a single point, or a batch of
+// already-encoded line-protocol lines.
+type payload interface {
+	write(ctx context.Context, api api.WriteAPIBlocking) error
+}
+
+type pointPayload struct{ point *write.Point }
+
+func (p pointPayload) write(ctx context.Context, api api.WriteAPIBlocking) error {
+	return api.WritePoint(ctx, p.point)
+}
+
+type linesPayload struct{ lines []string }
+
+func (p linesPayload) write(ctx context.Context, api api.WriteAPIBlocking) error {
+	return api.WriteRecord(ctx, p.lines...)
+}
+
+// job is a queued payload awaiting retry. A batch payload mixes lines
+// from many different requests, so a retried job has no single
+// originating span to attribute to; retries run under their own
+// background context instead.
+type job struct {
+	payload   payload
+	attempt   int
+	firstSeen time.Time
+	notBefore time.Time
+}
+
+// Writer retries transient InfluxDB write failures with capped
+// exponential backoff while dropping permanent ones. The zero value is
+// not usable; construct with New.
+type Writer struct {
+	api api.WriteAPIBlocking
+	cfg Config
+
+	mu      sync.Mutex
+	queue   []*job
+	dropped uint64 // permanent errors, and retries that ran out of attempts or time
+	evicted uint64 // queued jobs dropped to make room because the queue was full
+
+	wake chan struct{}
+	quit chan struct{}
+}
+
+// New returns a Writer that writes through api and retries transient
+// failures according to cfg. Call Start to begin the background
+// retry loop.
+func New(api api.WriteAPIBlocking, cfg Config) *Writer {
+	return &Writer{
+		api:  api,
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+	}
+}
+
+// Start launches the background goroutine that drains the retry
+// queue. It must be called once before Write is used.
+func (w *Writer) Start() {
+	go w.retryLoop()
+}
+
+// Stop halts the background retry loop. Queued points are discarded.
+func (w *Writer) Stop() {
+	close(w.quit)
+}
+
+// DroppedCount returns the number of points dropped because their
+// error was classified as permanent, or because their retries reached
+// MaxAttempts or MaxElapsed.
+func (w *Writer) DroppedCount() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// EvictedCount returns the number of queued points dropped, oldest
+// first, to make room for a new transient failure when the retry queue
+// was full.
+func (w *Writer) EvictedCount() uint64 {
+	return atomic.LoadUint64(&w.evicted)
+}
+
+// QueueDepth returns the number of points currently awaiting retry.
+func (w *Writer) QueueDepth() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.queue)
+}
+
+// Write attempts a synchronous write of p. Transient failures are
+// enqueued for background retry and reported to the caller as
+// success, since the point has not been lost. Permanent failures are
+// counted and returned to the caller. Ignorable responses (partial
+// writes, points outside the retention policy) are logged and treated
+// as success.
+func (w *Writer) Write(ctx context.Context, p *write.Point) error {
+	return w.attempt(ctx, pointPayload{point: p})
+}
+
+// WriteLines behaves like Write, but for a batch of pre-encoded
+// line-protocol lines rather than a single *write.Point. It's used by
+// the spool's flush loop, which already has line protocol on disk.
+func (w *Writer) WriteLines(ctx context.Context, lines []string) error {
+	return w.attempt(ctx, linesPayload{lines: lines})
+}
+
+func (w *Writer) attempt(ctx context.Context, p payload) error {
+	err := w.timedWrite(ctx, p)
+	if err == nil {
+		return nil
+	}
+
+	switch Classify(err) {
+	case ClassTransient:
+		w.enqueue(&job{payload: p, firstSeen: time.Now()})
+		return nil
+	case ClassIgnorable:
+		log.Printf("writer: ignorable write response, treating as success: %v", err)
+		return nil
+	default: // ClassPermanent
+		atomic.AddUint64(&w.dropped, 1)
+		return err
+	}
+}
+
+// timedWrite performs a single write attempt under its own span,
+// recording its duration and, on failure, its error classification.
+func (w *Writer) timedWrite(ctx context.Context, p payload) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "influxdb.write")
+	defer span.End()
+
+	start := time.Now()
+	err := p.write(ctx, w.api)
+	metrics.InfluxWriteDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		class := Classify(err)
+		metrics.InfluxWriteErrorsTotal.WithLabelValues(class.String()).Inc()
+		if class != ClassIgnorable {
+			span.RecordError(err)
+		}
+	}
+	return err
+}
+
+func (w *Writer) enqueue(j *job) {
+	w.mu.Lock()
+	if len(w.queue) >= w.cfg.QueueSize {
+		w.queue = w.queue[1:]
+		atomic.AddUint64(&w.evicted, 1)
+	}
+	w.queue = append(w.queue, j)
+	metrics.RetryQueueDepth.Set(float64(len(w.queue)))
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Writer) retryLoop() {
+	ticker := time.NewTicker(w.cfg.BaseDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-w.wake:
+			w.drain()
+		case <-ticker.C:
+			w.drain()
+		}
+	}
+}
+
+// drain walks the retry queue once, writing every job whose backoff
+// has elapsed and keeping the rest (or the next attempt, on renewed
+// failure) in place.
+func (w *Writer) drain() {
+	w.mu.Lock()
+	pending := w.queue
+	w.queue = nil
+	w.mu.Unlock()
+
+	now := time.Now()
+	var keep []*job
+	for _, j := range pending {
+		if now.Before(j.notBefore) {
+			keep = append(keep, j)
+			continue
+		}
+		if now.Sub(j.firstSeen) > w.cfg.MaxElapsed {
+			atomic.AddUint64(&w.dropped, 1)
+			continue
+		}
+
+		err := w.timedWrite(context.Background(), j.payload)
+		if err == nil {
+			continue
+		}
+
+		switch Classify(err) {
+		case ClassIgnorable:
+			log.Printf("writer: ignorable retry response, treating as success: %v", err)
+		case ClassPermanent:
+			atomic.AddUint64(&w.dropped, 1)
+		default: // ClassTransient
+			j.attempt++
+			if j.attempt >= w.cfg.MaxAttempts {
+				atomic.AddUint64(&w.dropped, 1)
+				continue
+			}
+			j.notBefore = now.Add(backoff(j.attempt, w.cfg.BaseDelay, w.cfg.MaxDelay))
+			keep = append(keep, j)
+		}
+	}
+
+	w.mu.Lock()
+	w.queue = append(keep, w.queue...)
+	metrics.RetryQueueDepth.Set(float64(len(w.queue)))
+	w.mu.Unlock()
+}
+
+// backoff computes a jittered, capped exponential delay for the given
+// attempt number (1-indexed).
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := float64(base) * math.Pow(2, float64(attempt-1))
+	if d > float64(max) {
+		d = float64(max)
+	}
+	jitter := 0.5 + rand.Float64()/2 // [0.5, 1.0)
+	return time.Duration(d * jitter)
+}
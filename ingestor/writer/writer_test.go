@@ -0,0 +1,37 @@
+package writer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffIsBoundedAndGrows(t *testing.T) {
+	base := 100 * time.Millisecond
+	max := 1 * time.Second
+
+	var prevMin time.Duration
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := backoff(attempt, base, max)
+		if d <= 0 {
+			t.Fatalf("attempt %d: backoff returned non-positive duration %v", attempt, d)
+		}
+		if d > max {
+			t.Fatalf("attempt %d: backoff %v exceeds max %v", attempt, d, max)
+		}
+
+		// The jittered floor (half of the unjittered delay) should
+		// climb with the attempt number until the cap takes over.
+		unjittered := float64(base) * float64(int64(1)<<uint(attempt-1))
+		if unjittered > float64(max) {
+			unjittered = float64(max)
+		}
+		floor := time.Duration(unjittered / 2)
+		if d < floor {
+			t.Fatalf("attempt %d: backoff %v is below its jittered floor %v", attempt, d, floor)
+		}
+		if floor < prevMin {
+			t.Fatalf("attempt %d: expected backoff floor to be non-decreasing, got %v after %v", attempt, floor, prevMin)
+		}
+		prevMin = floor
+	}
+}